@@ -0,0 +1,141 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package bpf
+
+import (
+	"context"
+	"encoding"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+
+	"github.com/cilium/cilium/pkg/statedb/reconciler"
+	"github.com/cilium/cilium/pkg/testutils"
+	"github.com/cilium/cilium/pkg/time"
+)
+
+// newFaultInjectionHive sets up the same reconciler harness as
+// Test_MapOps_ReconcilerExample, but with a short full-reconciliation
+// interval and backoff (so injected faults are retried quickly), and
+// returns the MapOps so the test can install a fault injector. The hive is
+// already started when this returns, with an empty table, so the injector
+// is in place before the test inserts any object.
+func newFaultInjectionHive(t *testing.T) (*MapOps[*TestObject], *reconcilerHarness) {
+	t.Helper()
+
+	exampleMap := newTestMap(t, "example_faults")
+	ops := NewMapOps[*TestObject](exampleMap)
+
+	h := newReconcilerHarness(t, "example_faults", ops, func(config *reconciler.Config[*TestObject]) {
+		config.FullReconcilationInterval = 50 * time.Millisecond
+		config.RetryBackoffMinDuration = 10 * time.Millisecond
+		config.RetryBackoffMaxDuration = 100 * time.Millisecond
+	})
+	t.Cleanup(func() { h.hive.Stop(context.Background()) })
+
+	return ops, h
+}
+
+// Test_MapOps_FaultInjection_TransientError asserts that a transient
+// failure is retried with backoff and the object eventually reconciles.
+func Test_MapOps_FaultInjection_TransientError(t *testing.T) {
+	testutils.PrivilegedTest(t)
+
+	ops, h := newFaultInjectionHive(t)
+
+	var failuresLeft = 2
+	ops.SetFaultInjector(func(op Op, key encoding.BinaryMarshaler) error {
+		if op != OpUpdate || failuresLeft <= 0 {
+			return nil
+		}
+		failuresLeft--
+		return unix.ENOMEM
+	})
+
+	txn := h.db.WriteTxn(h.table)
+	h.table.Insert(txn, &TestObject{
+		Key:    TestKey{1},
+		Value:  TestValue{2},
+		Status: reconciler.StatusPending(),
+	})
+	txn.Commit()
+
+	h.waitForStatus(t, 1, reconciler.StatusKindDone)
+}
+
+// Test_MapOps_FaultInjection_PermanentError asserts that a permanent
+// failure surfaces as StatusKindError with the injected errno preserved.
+func Test_MapOps_FaultInjection_PermanentError(t *testing.T) {
+	testutils.PrivilegedTest(t)
+
+	ops, h := newFaultInjectionHive(t)
+
+	ops.SetFaultInjector(func(op Op, key encoding.BinaryMarshaler) error {
+		if op == OpUpdate {
+			return unix.E2BIG
+		}
+		return nil
+	})
+
+	txn := h.db.WriteTxn(h.table)
+	h.table.Insert(txn, &TestObject{
+		Key:    TestKey{2},
+		Value:  TestValue{3},
+		Status: reconciler.StatusPending(),
+	})
+	txn.Commit()
+
+	obj := h.waitForStatus(t, 2, reconciler.StatusKindError)
+	require.ErrorIs(t, obj.Status.Error, unix.E2BIG, "errno should be preserved on the object status")
+}
+
+// Test_MapOps_FaultInjection_PruneFailureDoesNotWedge asserts that a Prune
+// failure on one key doesn't prevent later incremental rounds from
+// reconciling other keys.
+//
+// Deleting a key from the desired state table is reconciled through the
+// incremental Delete path, not Prune: Prune only ever touches drift, i.e.
+// entries present in the map that the table never knew about (e.g. left
+// behind by a previous agent run, or written by something else entirely).
+// So to exercise Prune we write a stray entry directly into the map,
+// bypassing the table and MapOps altogether.
+func Test_MapOps_FaultInjection_PruneFailureDoesNotWedge(t *testing.T) {
+	testutils.PrivilegedTest(t)
+
+	ops, h := newFaultInjectionHive(t)
+
+	require.NoError(t, ops.bpfMap.Update(&TestKey{3}, &TestValue{4}), "seed stray drift entry")
+
+	ops.SetFaultInjector(func(op Op, key encoding.BinaryMarshaler) error {
+		if op != OpPrune {
+			return nil
+		}
+		keyBytes, err := key.MarshalBinary()
+		if err != nil || len(keyBytes) == 0 || keyBytes[0] != 3 {
+			return nil
+		}
+		return errors.New("injected prune failure")
+	})
+
+	// Give the full-reconciliation interval (50ms, see
+	// newFaultInjectionHive) a few chances to run and fail to prune the
+	// stray entry.
+	time.Sleep(200 * time.Millisecond)
+	_, err := ops.bpfMap.Lookup(&TestKey{3})
+	require.NoError(t, err, "stray entry should still be present: its prune keeps failing")
+
+	// A later incremental round should still reconcile normally, i.e. the
+	// repeated prune failure must not wedge the reconciler.
+	txn := h.db.WriteTxn(h.table)
+	h.table.Insert(txn, &TestObject{
+		Key:    TestKey{5},
+		Value:  TestValue{6},
+		Status: reconciler.StatusPending(),
+	})
+	txn.Commit()
+
+	h.waitForStatus(t, 5, reconciler.StatusKindDone)
+}
@@ -0,0 +1,139 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package bpf
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/cilium/cilium/pkg/hive/cell"
+)
+
+const metricsNamespace = "cilium_bpf_map_ops"
+
+// Metrics holds the Prometheus metrics published by a MapOps (or
+// BatchMapOps) instance reconciling a single BPF map, once attached with
+// SetMetrics. MirrorMapOps does not publish them.
+type Metrics struct {
+	registerer  prometheus.Registerer
+	constLabels prometheus.Labels
+
+	// DesiredTotal is the total number of objects in the desired state
+	// table as of the last full reconciliation. It is not a measure of
+	// unreconciled work; see reconciler-level metrics for that.
+	DesiredTotal prometheus.Gauge
+	// UpdatesTotal counts successful map updates.
+	UpdatesTotal prometheus.Counter
+	// UpdateErrorsTotal counts failed map updates, labeled by errno.
+	UpdateErrorsTotal *prometheus.CounterVec
+	// DeletesTotal counts successful map deletes.
+	DeletesTotal prometheus.Counter
+	// PruneRemovalsTotal counts entries removed from the map by pruning.
+	PruneRemovalsTotal prometheus.Counter
+	// ReconciliationDurationSeconds is the duration of the last full
+	// reconciliation (prune pass).
+	ReconciliationDurationSeconds prometheus.Gauge
+	// Drift is the number of entries found in the map but not in the
+	// desired state table during the last full reconciliation.
+	Drift prometheus.Gauge
+}
+
+// NewMetrics creates and registers the reconciler metrics for the BPF map
+// named mapName against registerer.
+func NewMetrics(registerer prometheus.Registerer, mapName string) *Metrics {
+	m := &Metrics{
+		registerer:  registerer,
+		constLabels: prometheus.Labels{"map": mapName},
+	}
+	m.create()
+	return m
+}
+
+// collectors returns every metric owned by m, for bulk (un)registration.
+func (m *Metrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.DesiredTotal,
+		m.UpdatesTotal,
+		m.UpdateErrorsTotal,
+		m.DeletesTotal,
+		m.PruneRemovalsTotal,
+		m.ReconciliationDurationSeconds,
+		m.Drift,
+	}
+}
+
+func (m *Metrics) create() {
+	m.DesiredTotal = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace:   metricsNamespace,
+		Name:        "desired_total",
+		Help:        "Number of objects in the desired state table as of the last full reconciliation",
+		ConstLabels: m.constLabels,
+	})
+	m.UpdatesTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace:   metricsNamespace,
+		Name:        "updates_total",
+		Help:        "Number of successful map updates",
+		ConstLabels: m.constLabels,
+	})
+	m.UpdateErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   metricsNamespace,
+		Name:        "update_errors_total",
+		Help:        "Number of failed map updates, labeled by errno",
+		ConstLabels: m.constLabels,
+	}, []string{"errno"})
+	m.DeletesTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace:   metricsNamespace,
+		Name:        "deletes_total",
+		Help:        "Number of successful map deletes",
+		ConstLabels: m.constLabels,
+	})
+	m.PruneRemovalsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace:   metricsNamespace,
+		Name:        "prune_removals_total",
+		Help:        "Number of entries removed from the map by pruning",
+		ConstLabels: m.constLabels,
+	})
+	m.ReconciliationDurationSeconds = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace:   metricsNamespace,
+		Name:        "reconciliation_duration_seconds",
+		Help:        "Duration of the last full reconciliation",
+		ConstLabels: m.constLabels,
+	})
+	m.Drift = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace:   metricsNamespace,
+		Name:        "drift",
+		Help:        "Number of entries present in the map but not in the desired state table, as of the last full reconciliation",
+		ConstLabels: m.constLabels,
+	})
+
+	for _, c := range m.collectors() {
+		m.registerer.MustRegister(c)
+	}
+}
+
+// Reset unregisters and recreates every metric owned by m.
+//
+// Counters can't be decremented, so zeroing them out after a restart means
+// replacing the underlying collectors rather than setting them to zero.
+// This matters because the reconciler's hive cell.Module can be stopped and
+// started again independently of the process (e.g. after a previous run
+// crashed mid-reconciliation): without this, a restarted module would keep
+// publishing counts left over from the crashed instance, misrepresenting
+// the current state of the datapath.
+func (m *Metrics) Reset() {
+	for _, c := range m.collectors() {
+		m.registerer.Unregister(c)
+	}
+	m.create()
+}
+
+// RegisterLifecycle hooks m.Reset into lc's start hook, so that m is reset
+// every time the owning hive module (re)starts.
+func (m *Metrics) RegisterLifecycle(lc cell.Lifecycle) {
+	lc.Append(cell.Hook{
+		OnStart: func(cell.HookContext) error {
+			m.Reset()
+			return nil
+		},
+	})
+}
@@ -0,0 +1,132 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package bpf
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cilium/cilium/pkg/hive/cell"
+	"github.com/cilium/cilium/pkg/statedb/reconciler"
+	"github.com/cilium/cilium/pkg/testutils"
+)
+
+func gaugeValue(t *testing.T, g prometheus.Gauge) float64 {
+	t.Helper()
+	var m dto.Metric
+	require.NoError(t, g.Write(&m))
+	return m.GetGauge().GetValue()
+}
+
+func counterValue(t *testing.T, c prometheus.Counter) float64 {
+	t.Helper()
+	var m dto.Metric
+	require.NoError(t, c.Write(&m))
+	return m.GetCounter().GetValue()
+}
+
+// newMetricsReconcilerHive builds and starts a hive that reconciles
+// tableName into bpfMap via ops, publishing to metrics. It mirrors
+// newReconcilerHarness but additionally wires metrics.RegisterLifecycle so
+// the module resets its metrics every time it starts, to be called with a
+// fresh table/db pair each time, as a real module restart would.
+func newMetricsReconcilerHive(t *testing.T, metrics *Metrics, ops reconciler.Operations[*TestObject], tableName string) *reconcilerHarness {
+	t.Helper()
+
+	// Both *MapOps and *BatchMapOps (which embeds it) expose SetMetrics.
+	if m, ok := ops.(interface{ SetMetrics(*Metrics) }); ok {
+		m.SetMetrics(metrics)
+	}
+
+	return newReconcilerHarness(t, tableName, ops, nil,
+		cell.Invoke(func(lc cell.Lifecycle) { metrics.RegisterLifecycle(lc) }))
+}
+
+// Test_Metrics_ResetOnRestart drives a real reconciler through two
+// independent hive lifetimes sharing the same Metrics and underlying BPF
+// map, simulating a module that crashed and was recreated. It asserts that
+// the second module's gauges reflect only what it itself reconciled, not
+// leftover counts from the first.
+func Test_Metrics_ResetOnRestart(t *testing.T) {
+	testutils.PrivilegedTest(t)
+
+	bpfMap := newTestMap(t, "cilium_metrics_reset_test")
+
+	registry := prometheus.NewRegistry()
+	metrics := NewMetrics(registry, "reset_test")
+
+	h1 := newMetricsReconcilerHive(t, metrics, NewMapOps[*TestObject](bpfMap), "metrics_reset_1")
+	h1.insertAndWaitDone(t, &TestObject{
+		Key:    TestKey{1},
+		Value:  TestValue{2},
+		Status: reconciler.StatusPending(),
+	})
+	require.Equal(t, float64(1), counterValue(t, metrics.UpdatesTotal), "first module should have reconciled one update")
+	require.NoError(t, h1.hive.Stop(context.Background()), "Stop")
+
+	// Simulate the module being recreated after a crash: a brand new hive
+	// wraps the same (long-lived) Metrics instance.
+	h2 := newMetricsReconcilerHive(t, metrics, NewMapOps[*TestObject](bpfMap), "metrics_reset_2")
+	t.Cleanup(func() { h2.hive.Stop(context.Background()) })
+
+	require.Equal(t, float64(0), counterValue(t, metrics.UpdatesTotal), "UpdatesTotal should reset when the new module starts, before it reconciles anything")
+	require.Equal(t, float64(0), gaugeValue(t, metrics.Drift), "Drift should reset when the new module starts")
+
+	h2.insertAndWaitDone(t, &TestObject{
+		Key:    TestKey{2},
+		Value:  TestValue{3},
+		Status: reconciler.StatusPending(),
+	})
+	require.Equal(t, float64(1), counterValue(t, metrics.UpdatesTotal), "UpdatesTotal should reflect only the second module's own reconciliation")
+}
+
+// Test_Metrics_BatchMapOps asserts that UpdatesTotal and DeletesTotal are
+// published for entries applied through the batch success path, not just
+// the per-key fallback path that plain MapOps exercises above.
+func Test_Metrics_BatchMapOps(t *testing.T) {
+	testutils.PrivilegedTest(t)
+
+	bpfMap := newTestMap(t, "cilium_metrics_batch_test")
+
+	registry := prometheus.NewRegistry()
+	metrics := NewMetrics(registry, "batch_test")
+
+	ops := NewBatchMapOps[*TestObject](bpfMap, DefaultBatchSize)
+	h := newMetricsReconcilerHive(t, metrics, ops, "metrics_batch")
+	t.Cleanup(func() { h.hive.Stop(context.Background()) })
+
+	h.insertAndWaitDone(t, &TestObject{
+		Key:    TestKey{1},
+		Value:  TestValue{2},
+		Status: reconciler.StatusPending(),
+	})
+	h.insertAndWaitDone(t, &TestObject{
+		Key:    TestKey{2},
+		Value:  TestValue{3},
+		Status: reconciler.StatusPending(),
+	})
+	require.Equal(t, float64(2), counterValue(t, metrics.UpdatesTotal), "UpdatesTotal should count entries applied via BatchUpdate")
+
+	txn := h.db.WriteTxn(h.table)
+	h.table.Insert(txn, &TestObject{
+		Key:    TestKey{1},
+		Value:  TestValue{2},
+		Status: reconciler.StatusPendingDelete(),
+	})
+	txn.Commit()
+
+	for {
+		_, _, watch, ok := h.table.FirstWatch(h.db.ReadTxn(), h.keyIndex.Query(1))
+		if !ok {
+			break
+		}
+		<-watch
+	}
+
+	require.Equal(t, float64(1), counterValue(t, metrics.DeletesTotal), "DeletesTotal should count entries applied via BatchDelete")
+}
@@ -0,0 +1,246 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package bpf
+
+import (
+	"context"
+	"encoding"
+	"errors"
+	"fmt"
+
+	"github.com/cilium/cilium/pkg/lock"
+	"github.com/cilium/cilium/pkg/statedb"
+	"github.com/cilium/cilium/pkg/statedb/reconciler"
+)
+
+// MirrorMapOps fans out reconciliation of a single StateDB table to a
+// primary BPF map and one or more mirror maps kept in lockstep with it,
+// e.g. a shadow map used for zero-downtime map resizing/migration, or
+// per-CPU shards of the same logical table.
+//
+// Updates and deletes are always applied to the primary first. A mirror
+// that fails to apply makes the call return an error, but the primary and
+// the other mirrors are still written: MirrorMapOps remembers which mirrors
+// are still outstanding for a given key/value pair and only retries those
+// on the next Update call, so objects that are already correct in a mirror
+// aren't needlessly rewritten.
+type MirrorMapOps[Obj MapOpsObject] struct {
+	primary *MapOps[Obj]
+	mirrors []*MapOps[Obj]
+
+	mu      lock.Mutex
+	pending map[string]mirrorPending
+}
+
+type mirrorPending struct {
+	// value is the marshalled value this pending state was computed for.
+	// A new Update() call for the same key with a different value discards
+	// the old pending state, since the previous mirror writes are stale.
+	value string
+	// outstanding[i] is true if mirrors[i] still needs obj applied.
+	outstanding []bool
+}
+
+// NewMirrorMapOps returns a reconciler.Operations[Obj] that reconciles a
+// StateDB table into primary and replicates every change to each of
+// mirrors.
+func NewMirrorMapOps[Obj MapOpsObject](primary *Map, mirrors ...*Map) *MirrorMapOps[Obj] {
+	mirrorOps := make([]*MapOps[Obj], len(mirrors))
+	for i, m := range mirrors {
+		mirrorOps[i] = NewMapOps[Obj](m)
+	}
+	return &MirrorMapOps[Obj]{
+		primary: NewMapOps[Obj](primary),
+		mirrors: mirrorOps,
+		pending: map[string]mirrorPending{},
+	}
+}
+
+var _ reconciler.Operations[MapOpsObject] = (*MirrorMapOps[MapOpsObject])(nil)
+
+// Update applies obj to the primary map and then to every mirror that isn't
+// already known to hold it.
+func (ops *MirrorMapOps[Obj]) Update(ctx context.Context, txn statedb.ReadTxn, obj Obj, changed *bool) error {
+	keyBytes, err := obj.BinaryKey().MarshalBinary()
+	if err != nil {
+		return fmt.Errorf("marshal key: %w", err)
+	}
+	valueBytes, err := obj.BinaryValue().MarshalBinary()
+	if err != nil {
+		return fmt.Errorf("marshal value: %w", err)
+	}
+	trackingKey := string(keyBytes)
+
+	if err := ops.primary.Update(ctx, txn, obj, changed); err != nil {
+		return fmt.Errorf("primary: %w", err)
+	}
+
+	state := ops.loadOrResetPending(trackingKey, string(valueBytes))
+
+	var errs []error
+	for i, mirror := range ops.mirrors {
+		if !state.outstanding[i] {
+			continue
+		}
+		var mirrorChanged bool
+		if err := mirror.Update(ctx, txn, obj, &mirrorChanged); err != nil {
+			errs = append(errs, fmt.Errorf("mirror[%d]: %w", i, err))
+			continue
+		}
+		state.outstanding[i] = false
+	}
+
+	ops.storePending(trackingKey, state)
+	return errors.Join(errs...)
+}
+
+// Delete removes obj's key from the primary and every mirror.
+func (ops *MirrorMapOps[Obj]) Delete(ctx context.Context, txn statedb.ReadTxn, obj Obj) error {
+	keyBytes, err := obj.BinaryKey().MarshalBinary()
+	if err != nil {
+		return fmt.Errorf("marshal key: %w", err)
+	}
+
+	if err := ops.primary.Delete(ctx, txn, obj); err != nil {
+		return fmt.Errorf("primary: %w", err)
+	}
+
+	var errs []error
+	for i, mirror := range ops.mirrors {
+		if err := mirror.Delete(ctx, txn, obj); err != nil {
+			errs = append(errs, fmt.Errorf("mirror[%d]: %w", i, err))
+		}
+	}
+
+	ops.mu.Lock()
+	delete(ops.pending, string(keyBytes))
+	ops.mu.Unlock()
+
+	return errors.Join(errs...)
+}
+
+// Prune reconciles the primary against the desired state in iter, then
+// treats the (now pruned) primary as the source of truth and brings every
+// mirror in line with it via Diff.
+func (ops *MirrorMapOps[Obj]) Prune(ctx context.Context, txn statedb.ReadTxn, iter statedb.Iterator[Obj]) error {
+	if err := ops.primary.Prune(ctx, txn, iter); err != nil {
+		return fmt.Errorf("primary: %w", err)
+	}
+
+	var errs []error
+	for i, mirror := range ops.mirrors {
+		if err := reconcileMirror(ops.primary.bpfMap, mirror.bpfMap); err != nil {
+			errs = append(errs, fmt.Errorf("mirror[%d]: %w", i, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (ops *MirrorMapOps[Obj]) loadOrResetPending(trackingKey, value string) mirrorPending {
+	ops.mu.Lock()
+	defer ops.mu.Unlock()
+
+	state, ok := ops.pending[trackingKey]
+	if ok && state.value == value {
+		return state
+	}
+
+	outstanding := make([]bool, len(ops.mirrors))
+	for i := range outstanding {
+		outstanding[i] = true
+	}
+	return mirrorPending{value: value, outstanding: outstanding}
+}
+
+func (ops *MirrorMapOps[Obj]) storePending(trackingKey string, state mirrorPending) {
+	ops.mu.Lock()
+	defer ops.mu.Unlock()
+
+	for _, left := range state.outstanding {
+		if left {
+			ops.pending[trackingKey] = state
+			return
+		}
+	}
+	delete(ops.pending, trackingKey)
+}
+
+// Diff compares the contents of primary and mirror and returns the keys
+// that need to be written to mirror (missing or out of date) and the keys
+// that need to be removed from mirror (not present in primary). It is
+// exported primarily so tests can assert that a mirror has converged.
+func Diff(primary, mirror *Map) (toUpdate, toDelete []MapKey, err error) {
+	primaryValues := map[string][]byte{}
+	primaryKeys := map[string]MapKey{}
+	err = primary.DumpReliablyWithCallback(func(key MapKey, _ MapValue, keyBytes, valueBytes []byte) {
+		primaryValues[string(keyBytes)] = valueBytes
+		primaryKeys[string(keyBytes)] = key
+	}, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("dump primary: %w", err)
+	}
+
+	mirrorValues := map[string][]byte{}
+	mirrorKeys := map[string]MapKey{}
+	err = mirror.DumpReliablyWithCallback(func(key MapKey, _ MapValue, keyBytes, valueBytes []byte) {
+		mirrorValues[string(keyBytes)] = valueBytes
+		mirrorKeys[string(keyBytes)] = key
+	}, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("dump mirror: %w", err)
+	}
+
+	for keyBytes, primaryValue := range primaryValues {
+		if mirrorValue, ok := mirrorValues[keyBytes]; !ok || string(mirrorValue) != string(primaryValue) {
+			// Use the primary's MapKey: the mirror may not have this key at
+			// all (mirrorKeys[keyBytes] would be nil), which is precisely
+			// the case a behind mirror needs to be populated for.
+			toUpdate = append(toUpdate, primaryKeys[keyBytes])
+		}
+	}
+	for keyBytes := range mirrorValues {
+		if _, ok := primaryValues[keyBytes]; !ok {
+			toDelete = append(toDelete, mirrorKeys[keyBytes])
+		}
+	}
+	return toUpdate, toDelete, nil
+}
+
+// reconcileMirror brings mirror's contents in line with primary's, treating
+// primary as the source of truth.
+func reconcileMirror(primary, mirror *Map) error {
+	toUpdate, toDelete, err := Diff(primary, mirror)
+	if err != nil {
+		return err
+	}
+	for _, key := range toUpdate {
+		keyMarshaler, ok := key.(encoding.BinaryMarshaler)
+		if !ok {
+			return fmt.Errorf("key %s does not support binary marshalling", key)
+		}
+		value, err := primary.Lookup(keyMarshaler)
+		if err != nil {
+			// The key disappeared from primary between Diff and now; the
+			// next reconciliation round will pick it up (or its absence).
+			continue
+		}
+		valueMarshaler, ok := value.(encoding.BinaryMarshaler)
+		if !ok {
+			return fmt.Errorf("value for key %s does not support binary marshalling", key)
+		}
+		if err := mirror.Update(keyMarshaler, valueMarshaler); err != nil {
+			return fmt.Errorf("update %s: %w", key, err)
+		}
+	}
+	for _, key := range toDelete {
+		keyMarshaler, ok := key.(encoding.BinaryMarshaler)
+		if !ok {
+			return fmt.Errorf("key %s does not support binary marshalling", key)
+		}
+		if err := mirror.Delete(keyMarshaler); err != nil && !IsMapKeyNotExist(err) {
+			return fmt.Errorf("delete %s: %w", key, err)
+		}
+	}
+	return nil
+}
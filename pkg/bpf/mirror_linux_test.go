@@ -0,0 +1,79 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package bpf
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cilium/cilium/pkg/testutils"
+)
+
+func Test_MirrorMapOps(t *testing.T) {
+	testutils.PrivilegedTest(t)
+
+	primaryMap := newTestMap(t, "cilium_mirror_primary_test")
+	mirrorMap := newTestMap(t, "cilium_mirror_shadow_test")
+
+	ctx := context.TODO()
+	ops := NewMirrorMapOps[*TestObject](primaryMap, mirrorMap)
+	obj := &TestObject{Key: TestKey{1}, Value: TestValue{2}}
+
+	var changed bool
+	require.NoError(t, ops.Update(ctx, nil, obj, &changed), "Update")
+	assert.True(t, changed)
+
+	for _, m := range []*Map{primaryMap, mirrorMap} {
+		v, err := m.Lookup(&TestKey{1})
+		if assert.NoError(t, err, "Lookup") {
+			assert.Equal(t, uint32(2), v.(*TestValue).Value)
+		}
+	}
+
+	toUpdate, toDelete, err := Diff(primaryMap, mirrorMap)
+	require.NoError(t, err, "Diff")
+	assert.Empty(t, toUpdate, "mirror should already match primary")
+	assert.Empty(t, toDelete, "mirror should already match primary")
+
+	require.NoError(t, ops.Delete(ctx, nil, obj), "Delete")
+	for _, m := range []*Map{primaryMap, mirrorMap} {
+		_, err := m.Lookup(&TestKey{1})
+		assert.Error(t, err, "Lookup after Delete")
+	}
+}
+
+// Test_MirrorMapOps_ReconcileBehindMirror covers the dominant case for a
+// newly-introduced mirror (e.g. a shadow map for an in-progress resize):
+// it has entries missing that already exist in the primary. reconcileMirror
+// must be able to populate those, not just remove stale ones.
+func Test_MirrorMapOps_ReconcileBehindMirror(t *testing.T) {
+	testutils.PrivilegedTest(t)
+
+	primaryMap := newTestMap(t, "cilium_mirror_primary_behind_test")
+	mirrorMap := newTestMap(t, "cilium_mirror_shadow_behind_test")
+
+	// Write directly to the primary, bypassing MirrorMapOps, so the mirror
+	// starts out completely behind.
+	require.NoError(t, primaryMap.Update(&TestKey{7}, &TestValue{8}))
+
+	toUpdate, toDelete, err := Diff(primaryMap, mirrorMap)
+	require.NoError(t, err, "Diff")
+	require.Len(t, toUpdate, 1, "mirror should be missing the primary's entry")
+	assert.Empty(t, toDelete)
+
+	require.NoError(t, reconcileMirror(primaryMap, mirrorMap), "reconcileMirror")
+
+	v, err := mirrorMap.Lookup(&TestKey{7})
+	if assert.NoError(t, err, "Lookup") {
+		assert.Equal(t, uint32(8), v.(*TestValue).Value)
+	}
+
+	toUpdate, toDelete, err = Diff(primaryMap, mirrorMap)
+	require.NoError(t, err, "Diff")
+	assert.Empty(t, toUpdate, "mirror should now match primary")
+	assert.Empty(t, toDelete)
+}
@@ -0,0 +1,200 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package bpf
+
+import (
+	"context"
+	"errors"
+
+	"github.com/cilium/ebpf"
+	"golang.org/x/sys/unix"
+
+	"github.com/cilium/cilium/pkg/statedb"
+	"github.com/cilium/cilium/pkg/statedb/reconciler"
+)
+
+// DefaultBatchSize bounds how many keys are grouped into a single
+// BPF_MAP_UPDATE_BATCH or BPF_MAP_DELETE_BATCH syscall. It can be lowered
+// per-instance via NewBatchMapOps, and is further capped by the size of the
+// batch the reconciler hands us, which in turn is bounded by
+// reconciler.Config.IncrementalRoundSize.
+const DefaultBatchSize = 4096
+
+// BatchMapOps extends MapOps with reconciler.BatchOperations, applying
+// updates and deletes with BPF_MAP_UPDATE_BATCH/BPF_MAP_DELETE_BATCH instead
+// of one syscall per key. If the map type or the running kernel doesn't
+// support batched operations it transparently falls back to the per-key
+// operations inherited from MapOps.
+type BatchMapOps[Obj MapOpsObject] struct {
+	*MapOps[Obj]
+
+	batchSize      int
+	batchSupported bool
+}
+
+// NewBatchMapOps returns a reconciler.Operations[Obj] that also implements
+// reconciler.BatchOperations[Obj] for bpfMap. batchSize, if positive, bounds
+// how many keys are submitted in a single BPF_MAP_*_BATCH call; non-positive
+// values fall back to DefaultBatchSize.
+func NewBatchMapOps[Obj MapOpsObject](bpfMap *Map, batchSize int) *BatchMapOps[Obj] {
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	return &BatchMapOps[Obj]{
+		MapOps:         NewMapOps[Obj](bpfMap),
+		batchSize:      batchSize,
+		batchSupported: supportsBatchAPI(bpfMap.Type()),
+	}
+}
+
+var _ reconciler.Operations[MapOpsObject] = (*BatchMapOps[MapOpsObject])(nil)
+var _ reconciler.BatchOperations[MapOpsObject] = (*BatchMapOps[MapOpsObject])(nil)
+
+// UpdateBatch implements reconciler.BatchOperations.
+func (ops *BatchMapOps[Obj]) UpdateBatch(ctx context.Context, txn statedb.ReadTxn, batch []reconciler.BatchEntry[Obj]) {
+	for start := 0; start < len(batch); start += ops.batchSize {
+		if !ops.batchSupported {
+			ops.fallbackUpdate(ctx, txn, batch[start:])
+			return
+		}
+
+		end := min(start+ops.batchSize, len(batch))
+		chunk := batch[start:end]
+
+		keys, values, err := marshalEntries(chunk)
+		if err != nil {
+			// A marshalling failure can't be attributed to the kernel, so
+			// surface it on every entry in the chunk and move on.
+			for i := range chunk {
+				chunk[i].Result = err
+			}
+			continue
+		}
+
+		count, err := ops.ebpfMap().BatchUpdate(keys, values, nil)
+		if count > 0 && ops.metrics != nil {
+			ops.metrics.UpdatesTotal.Add(float64(count))
+		}
+		if err == nil {
+			continue
+		}
+
+		if isBatchUnsupported(err) {
+			// The kernel doesn't support batching at all: stop burning a
+			// doomed syscall per chunk for the rest of this (and every
+			// subsequent) round.
+			ops.batchSupported = false
+		}
+
+		// The batch partially applied (or didn't apply at all). Retry the
+		// remainder one key at a time so a single bad entry doesn't fail
+		// the whole chunk, and so each object gets its own errno.
+		ops.fallbackUpdate(ctx, txn, chunk[count:])
+	}
+}
+
+// DeleteBatch implements reconciler.BatchOperations.
+func (ops *BatchMapOps[Obj]) DeleteBatch(ctx context.Context, txn statedb.ReadTxn, batch []reconciler.BatchEntry[Obj]) {
+	for start := 0; start < len(batch); start += ops.batchSize {
+		if !ops.batchSupported {
+			ops.fallbackDelete(ctx, txn, batch[start:])
+			return
+		}
+
+		end := min(start+ops.batchSize, len(batch))
+		chunk := batch[start:end]
+
+		keys, err := marshalKeys(chunk)
+		if err != nil {
+			// Keep keys 1:1 with chunk so that a partial BatchDelete's
+			// count below always indexes correctly into chunk; a
+			// marshalling failure can't be attributed to the kernel, so
+			// surface it on every entry and move on.
+			for i := range chunk {
+				chunk[i].Result = err
+			}
+			continue
+		}
+
+		count, err := ops.ebpfMap().BatchDelete(keys, nil)
+		if count > 0 && ops.metrics != nil {
+			ops.metrics.DeletesTotal.Add(float64(count))
+		}
+		if err == nil {
+			continue
+		}
+
+		if isBatchUnsupported(err) {
+			ops.batchSupported = false
+		}
+
+		ops.fallbackDelete(ctx, txn, chunk[count:])
+	}
+}
+
+func marshalKeys[Obj MapOpsObject](batch []reconciler.BatchEntry[Obj]) ([][]byte, error) {
+	keys := make([][]byte, len(batch))
+	for i, entry := range batch {
+		keyBytes, err := entry.Object.BinaryKey().MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = keyBytes
+	}
+	return keys, nil
+}
+
+func (ops *BatchMapOps[Obj]) fallbackUpdate(ctx context.Context, txn statedb.ReadTxn, batch []reconciler.BatchEntry[Obj]) {
+	for i := range batch {
+		var changed bool
+		batch[i].Result = ops.MapOps.Update(ctx, txn, batch[i].Object, &changed)
+	}
+}
+
+func (ops *BatchMapOps[Obj]) fallbackDelete(ctx context.Context, txn statedb.ReadTxn, batch []reconciler.BatchEntry[Obj]) {
+	for i := range batch {
+		batch[i].Result = ops.MapOps.Delete(ctx, txn, batch[i].Object)
+	}
+}
+
+func (ops *BatchMapOps[Obj]) ebpfMap() *ebpf.Map {
+	return ops.MapOps.bpfMap.EbpfMap()
+}
+
+func marshalEntries[Obj MapOpsObject](batch []reconciler.BatchEntry[Obj]) (keys, values [][]byte, err error) {
+	keys = make([][]byte, 0, len(batch))
+	values = make([][]byte, 0, len(batch))
+	for _, entry := range batch {
+		keyBytes, err := entry.Object.BinaryKey().MarshalBinary()
+		if err != nil {
+			return nil, nil, err
+		}
+		valueBytes, err := entry.Object.BinaryValue().MarshalBinary()
+		if err != nil {
+			return nil, nil, err
+		}
+		keys = append(keys, keyBytes)
+		values = append(values, valueBytes)
+	}
+	return keys, values, nil
+}
+
+// supportsBatchAPI reports whether the given map type supports the
+// BPF_MAP_*_BATCH commands. Some map types (e.g. LPM tries, stack/queue)
+// don't implement them in any kernel version.
+func supportsBatchAPI(t ebpf.MapType) bool {
+	switch t {
+	case ebpf.LPMTrie, ebpf.Stack, ebpf.Queue, ebpf.StackTrace:
+		return false
+	default:
+		return true
+	}
+}
+
+// isBatchUnsupported reports whether err indicates that the running kernel
+// rejected the batch syscall outright, as opposed to a per-key failure
+// within an otherwise-supported batch call.
+func isBatchUnsupported(err error) bool {
+	return errors.Is(err, ebpf.ErrNotSupported) || errors.Is(err, unix.EINVAL)
+}
@@ -0,0 +1,226 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package bpf
+
+import (
+	"context"
+	"encoding"
+	"errors"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/cilium/cilium/pkg/statedb"
+	"github.com/cilium/cilium/pkg/statedb/reconciler"
+	"github.com/cilium/cilium/pkg/time"
+)
+
+// MapOpsObject is implemented by the objects stored in the StateDB table that
+// is reconciled into a BPF map by MapOps.
+type MapOpsObject interface {
+	// BinaryKey returns the binary encoding of the map key.
+	BinaryKey() encoding.BinaryMarshaler
+	// BinaryValue returns the binary encoding of the map value.
+	BinaryValue() encoding.BinaryMarshaler
+}
+
+// MapOps implements reconciler.Operations against a BPF map by issuing one
+// bpf() syscall per key. It is constructed with NewMapOps and registered
+// with the reconciler as the Operations (and optionally BatchOperations,
+// see NewBatchMapOps) for a StateDB table.
+type MapOps[Obj MapOpsObject] struct {
+	bpfMap        *Map
+	metrics       *Metrics
+	faultInjector func(op Op, key encoding.BinaryMarshaler) error
+}
+
+// Op identifies which MapOps operation SetFaultInjector is being consulted
+// for.
+type Op int
+
+const (
+	OpUpdate Op = iota
+	OpDelete
+	OpPrune
+)
+
+func (op Op) String() string {
+	switch op {
+	case OpUpdate:
+		return "update"
+	case OpDelete:
+		return "delete"
+	case OpPrune:
+		return "prune"
+	default:
+		return "unknown"
+	}
+}
+
+// SetFaultInjector installs fn to be consulted before every per-key map
+// update and delete MapOps makes, and before each entry removal during
+// Prune. Lookups, the map dump in Prune and the batched calls of
+// BatchMapOps do not consult it. If fn returns a non-nil error for a given
+// (op, key), MapOps returns that error instead of performing the call,
+// letting tests force specific keys to fail (e.g. with unix.E2BIG or
+// unix.ENOMEM) to exercise the reconciler's retry/backoff and partial-
+// failure handling.
+//
+// This is a test-only hook: production callers must never set it, and the
+// zero value (nil) is the normal, no-op path.
+func (ops *MapOps[Obj]) SetFaultInjector(fn func(op Op, key encoding.BinaryMarshaler) error) {
+	ops.faultInjector = fn
+}
+
+// rawKey wraps already-marshalled key bytes so they can be passed to the
+// fault injector without re-marshalling them.
+type rawKey []byte
+
+func (k rawKey) MarshalBinary() ([]byte, error) {
+	return []byte(k), nil
+}
+
+// NewMapOps returns a reconciler.Operations[Obj] that reconciles the objects
+// of a StateDB table into the given BPF map, one key at a time.
+func NewMapOps[Obj MapOpsObject](bpfMap *Map) *MapOps[Obj] {
+	return &MapOps[Obj]{bpfMap: bpfMap}
+}
+
+// SetMetrics attaches m to ops, so that subsequent Update/Delete/Prune calls
+// publish their outcome to it. It is nil-safe to call with a nil ops.metrics
+// already set and is not safe to call concurrently with reconciliation.
+func (ops *MapOps[Obj]) SetMetrics(m *Metrics) {
+	ops.metrics = m
+}
+
+var _ reconciler.Operations[MapOpsObject] = (*MapOps[MapOpsObject])(nil)
+
+// Update reconciles the object into the map, creating or overwriting the
+// entry at obj's key.
+func (ops *MapOps[Obj]) Update(ctx context.Context, txn statedb.ReadTxn, obj Obj, changed *bool) error {
+	key := obj.BinaryKey()
+	value := obj.BinaryValue()
+
+	*changed = ops.hasChanged(key, value)
+
+	var err error
+	if ops.faultInjector != nil {
+		err = ops.faultInjector(OpUpdate, key)
+	}
+	if err == nil {
+		err = ops.bpfMap.Update(key, value)
+	}
+	if ops.metrics != nil {
+		if err != nil {
+			ops.metrics.UpdateErrorsTotal.WithLabelValues(errnoLabel(err)).Inc()
+		} else {
+			ops.metrics.UpdatesTotal.Inc()
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("update %T: %w", obj, err)
+	}
+	return nil
+}
+
+// Delete removes the entry for obj's key from the map. A missing entry is
+// not an error as the desired end state (the key being absent) already holds.
+func (ops *MapOps[Obj]) Delete(ctx context.Context, txn statedb.ReadTxn, obj Obj) error {
+	key := obj.BinaryKey()
+
+	var err error
+	if ops.faultInjector != nil {
+		err = ops.faultInjector(OpDelete, key)
+	}
+	if err == nil {
+		err = ops.bpfMap.Delete(key)
+	}
+	if err != nil && !IsMapKeyNotExist(err) {
+		return fmt.Errorf("delete %T: %w", obj, err)
+	}
+	if err == nil && ops.metrics != nil {
+		// Only count actual removals; a key-not-exist no-op didn't change
+		// the map.
+		ops.metrics.DeletesTotal.Inc()
+	}
+	return nil
+}
+
+// Prune removes entries from the map that are not present in [iter], which
+// enumerates the full desired state of the table.
+func (ops *MapOps[Obj]) Prune(ctx context.Context, txn statedb.ReadTxn, iter statedb.Iterator[Obj]) error {
+	start := time.Now()
+
+	desired := map[string]struct{}{}
+	for obj, _, ok := iter.Next(); ok; obj, _, ok = iter.Next() {
+		keyBytes, err := obj.BinaryKey().MarshalBinary()
+		if err != nil {
+			return fmt.Errorf("marshal key of %T: %w", obj, err)
+		}
+		desired[string(keyBytes)] = struct{}{}
+	}
+
+	var undesired, removed int
+	err := ops.bpfMap.DumpReliablyWithCallback(func(key MapKey, _ MapValue, keyBytes []byte, _ []byte) {
+		if _, ok := desired[string(keyBytes)]; ok {
+			return
+		}
+		undesired++
+		if ops.faultInjector != nil {
+			// A failure here is not fatal to the round: the entry is left
+			// in the map and picked up by the next full reconciliation.
+			if err := ops.faultInjector(OpPrune, rawKey(keyBytes)); err != nil {
+				return
+			}
+		}
+		if err := ops.bpfMap.Delete(key); err == nil {
+			removed++
+		}
+	}, nil)
+
+	if ops.metrics != nil {
+		ops.metrics.DesiredTotal.Set(float64(len(desired)))
+		ops.metrics.PruneRemovalsTotal.Add(float64(removed))
+		// Drift is "present in the map but not in the desired table", i.e.
+		// every undesired entry observed this round, regardless of whether
+		// its removal actually succeeded.
+		ops.metrics.Drift.Set(float64(undesired))
+		ops.metrics.ReconciliationDurationSeconds.Set(time.Since(start).Seconds())
+	}
+	return err
+}
+
+// errnoLabel extracts the unix.Errno from err for use as a Prometheus label
+// value, falling back to "unknown" for errors that didn't originate from the
+// bpf() syscall.
+func errnoLabel(err error) string {
+	var errno unix.Errno
+	if errors.As(err, &errno) {
+		return errno.Error()
+	}
+	return "unknown"
+}
+
+// hasChanged looks up the current value for key and compares it against
+// value to determine whether the update is a no-op. Failure to look up the
+// previous value (e.g. the key is new) is treated as a change.
+func (ops *MapOps[Obj]) hasChanged(key, value encoding.BinaryMarshaler) bool {
+	prev, err := ops.bpfMap.Lookup(key)
+	if err != nil {
+		return true
+	}
+	prevMarshaler, ok := prev.(encoding.BinaryMarshaler)
+	if !ok {
+		return true
+	}
+	prevBytes, err := prevMarshaler.MarshalBinary()
+	if err != nil {
+		return true
+	}
+	newBytes, err := value.MarshalBinary()
+	if err != nil {
+		return true
+	}
+	return string(prevBytes) != string(newBytes)
+}
@@ -8,21 +8,14 @@ import (
 	"encoding"
 	"testing"
 
-	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/cilium/ebpf"
 
-	"github.com/cilium/cilium/pkg/hive"
-	"github.com/cilium/cilium/pkg/hive/cell"
-	"github.com/cilium/cilium/pkg/hive/job"
-	"github.com/cilium/cilium/pkg/logging"
 	"github.com/cilium/cilium/pkg/statedb"
-	"github.com/cilium/cilium/pkg/statedb/index"
 	"github.com/cilium/cilium/pkg/statedb/reconciler"
 	"github.com/cilium/cilium/pkg/testutils"
-	"github.com/cilium/cilium/pkg/time"
 )
 
 type TestObject struct {
@@ -50,17 +43,7 @@ var _ statedb.Iterator[*TestObject] = &emptyIterator{}
 func Test_MapOps(t *testing.T) {
 	testutils.PrivilegedTest(t)
 
-	testMap := NewMap("cilium_ops_test",
-		ebpf.Hash,
-		&TestKey{},
-		&TestValue{},
-		maxEntries,
-		BPF_F_NO_PREALLOC,
-	)
-
-	err := testMap.OpenOrCreate()
-	require.NoError(t, err, "OpenOrCreate")
-	defer testMap.Close()
+	testMap := newTestMap(t, "cilium_ops_test")
 
 	ctx := context.TODO()
 	ops := NewMapOps[*TestObject](testMap)
@@ -68,7 +51,7 @@ func Test_MapOps(t *testing.T) {
 
 	// Test Update() and Delete()
 	changed := false
-	err = ops.Update(ctx, nil, obj, &changed)
+	err := ops.Update(ctx, nil, obj, &changed)
 	assert.NoError(t, err, "Update")
 	assert.True(t, changed, "should have changed on first update")
 
@@ -105,125 +88,70 @@ func Test_MapOps(t *testing.T) {
 	assert.Len(t, data, 0)
 }
 
+func Test_BatchMapOps(t *testing.T) {
+	testutils.PrivilegedTest(t)
+
+	testMap := newTestMap(t, "cilium_batch_ops_test")
+
+	ctx := context.TODO()
+	// Use a small batch size so the test exercises chunking across multiple
+	// BPF_MAP_UPDATE_BATCH calls.
+	ops := NewBatchMapOps[*TestObject](testMap, 2)
+
+	batch := make([]reconciler.BatchEntry[*TestObject], 5)
+	for i := range batch {
+		batch[i].Object = &TestObject{Key: TestKey{uint32(i)}, Value: TestValue{uint32(i * 10)}}
+	}
+
+	ops.UpdateBatch(ctx, nil, batch)
+	for i, entry := range batch {
+		assert.NoError(t, entry.Result, "UpdateBatch entry %d", i)
+	}
+
+	for i := range batch {
+		v, err := testMap.Lookup(&TestKey{uint32(i)})
+		if assert.NoError(t, err, "Lookup") {
+			assert.Equal(t, uint32(i*10), v.(*TestValue).Value)
+		}
+	}
+
+	ops.DeleteBatch(ctx, nil, batch)
+	for i, entry := range batch {
+		assert.NoError(t, entry.Result, "DeleteBatch entry %d", i)
+	}
+
+	data := map[string][]string{}
+	testMap.Dump(data)
+	assert.Len(t, data, 0)
+}
+
 // Test_MapOps_ReconcilerExample serves as a testable example for the map ops.
 // This is not an "Example*" function as it can only run privileged.
 func Test_MapOps_ReconcilerExample(t *testing.T) {
 	testutils.PrivilegedTest(t)
 
-	exampleMap := NewMap("example",
-		ebpf.Hash,
-		&TestKey{},
-		&TestValue{},
-		maxEntries,
-		BPF_F_NO_PREALLOC,
-	)
-	err := exampleMap.OpenOrCreate()
-	require.NoError(t, err)
-	defer exampleMap.Close()
-
-	// Create the map operations and the reconciler configuration.
+	exampleMap := newTestMap(t, "example")
 	ops := NewMapOps[*TestObject](exampleMap)
-	config := reconciler.Config[*TestObject]{
-		FullReconcilationInterval: time.Minute,
-		RetryBackoffMinDuration:   100 * time.Millisecond,
-		RetryBackoffMaxDuration:   10 * time.Second,
-		IncrementalRoundSize:      1000,
-		GetObjectStatus: func(obj *TestObject) reconciler.Status {
-			return obj.Status
-		},
-		WithObjectStatus: func(obj *TestObject, s reconciler.Status) *TestObject {
-			obj2 := *obj
-			obj2.Status = s
-			return &obj2
-		},
-		Operations:      ops,
-		BatchOperations: nil,
-	}
 
-	// Create the table containing the desired state of the map.
-	keyIndex := statedb.Index[*TestObject, uint32]{
-		Name: "example",
-		FromObject: func(obj *TestObject) index.KeySet {
-			return index.NewKeySet(index.Uint32(obj.Key.Key))
-		},
-		FromKey: index.Uint32,
-		Unique:  true,
-	}
-	table, err := statedb.NewTable("example", keyIndex)
-	require.NoError(t, err, "NewTable")
-
-	// Silence the hive log output.
-	oldLogLevel := logging.DefaultLogger.GetLevel()
-	logging.SetLogLevel(logrus.ErrorLevel)
-	t.Cleanup(func() {
-		logging.SetLogLevel(oldLogLevel)
-	})
-
-	// Setup and start a hive to run the reconciler.
-	var db *statedb.DB
-	h := hive.New(
-		statedb.Cell,
-		reconciler.Cell,
-		job.Cell,
-
-		cell.Module(
-			"example",
-			"Example",
-
-			cell.Provide(
-				func(db_ *statedb.DB) (statedb.RWTable[*TestObject], error) {
-					db = db_
-					return table, db.RegisterTable(table)
-				},
-				func() reconciler.Config[*TestObject] {
-					return config
-				},
-			),
-			cell.Invoke(
-				reconciler.Register[*TestObject],
-			),
-		),
-	)
-
-	err = h.Start(context.Background())
-	require.NoError(t, err, "Start")
-
-	t.Cleanup(func() {
-		h.Stop(context.Background())
-	})
+	h := newReconcilerHarness(t, "example", ops, nil)
+	t.Cleanup(func() { h.hive.Stop(context.Background()) })
 
 	// Insert an object to the desired state and wait for it to reconcile.
-	txn := db.WriteTxn(table)
-	table.Insert(txn, &TestObject{
-		Key:   TestKey{1},
-		Value: TestValue{2},
-
-		// Mark the object to be pending for reconciliation. Without this
-		// the reconciler would ignore this object.
+	// Mark the object to be pending for reconciliation: without this the
+	// reconciler would ignore this object.
+	h.insertAndWaitDone(t, &TestObject{
+		Key:    TestKey{1},
+		Value:  TestValue{2},
 		Status: reconciler.StatusPending(),
 	})
-	txn.Commit()
-
-	for {
-		obj, _, watch, ok := table.FirstWatch(db.ReadTxn(), keyIndex.Query(1))
-		if ok {
-			if obj.Status.Kind == reconciler.StatusKindDone {
-				// The object has been reconciled.
-				break
-			}
-			t.Logf("Object not done yet: %#v", obj)
-		}
-		// Wait for the object to update
-		<-watch
-	}
 
 	v, err := exampleMap.Lookup(&TestKey{1})
 	require.NoError(t, err, "Lookup")
 	require.Equal(t, uint32(2), v.(*TestValue).Value)
 
 	// Mark the object for deletion
-	txn = db.WriteTxn(table)
-	table.Insert(txn, &TestObject{
+	txn := h.db.WriteTxn(h.table)
+	h.table.Insert(txn, &TestObject{
 		Key:    TestKey{1},
 		Value:  TestValue{2},
 		Status: reconciler.StatusPendingDelete(),
@@ -231,7 +159,7 @@ func Test_MapOps_ReconcilerExample(t *testing.T) {
 	txn.Commit()
 
 	for {
-		obj, _, watch, ok := table.FirstWatch(db.ReadTxn(), keyIndex.Query(1))
+		obj, _, watch, ok := h.table.FirstWatch(h.db.ReadTxn(), h.keyIndex.Query(1))
 		if !ok {
 			// The object has been successfully deleted.
 			break
@@ -0,0 +1,152 @@
+// SPDX-License-Identifier: Apache-2.0
+// Copyright Authors of Cilium
+
+package bpf
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cilium/ebpf"
+
+	"github.com/cilium/cilium/pkg/hive"
+	"github.com/cilium/cilium/pkg/hive/cell"
+	"github.com/cilium/cilium/pkg/hive/job"
+	"github.com/cilium/cilium/pkg/logging"
+	"github.com/cilium/cilium/pkg/statedb"
+	"github.com/cilium/cilium/pkg/statedb/index"
+	"github.com/cilium/cilium/pkg/statedb/reconciler"
+	"github.com/cilium/cilium/pkg/time"
+)
+
+// newTestMap opens (creating if needed) a hash map named name using the
+// TestKey/TestValue types shared by this package's tests, and registers its
+// Close with t.Cleanup.
+func newTestMap(t *testing.T, name string) *Map {
+	t.Helper()
+
+	m := NewMap(name,
+		ebpf.Hash,
+		&TestKey{},
+		&TestValue{},
+		maxEntries,
+		BPF_F_NO_PREALLOC,
+	)
+	require.NoError(t, m.OpenOrCreate(), "OpenOrCreate")
+	t.Cleanup(func() { m.Close() })
+	return m
+}
+
+// reconcilerHarness bundles the StateDB table and hive a test reconciles
+// TestObjects through, as returned by newReconcilerHarness.
+type reconcilerHarness struct {
+	db       *statedb.DB
+	table    statedb.RWTable[*TestObject]
+	keyIndex statedb.Index[*TestObject, uint32]
+	hive     *hive.Hive
+}
+
+// newReconcilerHarness builds and starts a hive that reconciles a table
+// named tableName into ops (and, if ops also implements
+// reconciler.BatchOperations, via batching too). configure, if non-nil, is
+// given the chance to adjust the reconciler.Config before the hive starts,
+// e.g. to shorten the full-reconciliation interval or backoff durations.
+// extra cells (e.g. additional cell.Invoke hooks) are added to the module.
+//
+// It silences hive's log output for the duration of the test. The caller
+// owns the returned hive's lifetime and is responsible for stopping it
+// (typically via t.Cleanup), since some tests need to stop and replace it
+// mid-test (e.g. to simulate a module restart).
+func newReconcilerHarness(t *testing.T, tableName string, ops reconciler.Operations[*TestObject], configure func(*reconciler.Config[*TestObject]), extra ...cell.Cell) *reconcilerHarness {
+	t.Helper()
+
+	config := reconciler.Config[*TestObject]{
+		FullReconcilationInterval: time.Minute,
+		RetryBackoffMinDuration:   100 * time.Millisecond,
+		RetryBackoffMaxDuration:   10 * time.Second,
+		IncrementalRoundSize:      1000,
+		GetObjectStatus: func(obj *TestObject) reconciler.Status {
+			return obj.Status
+		},
+		WithObjectStatus: func(obj *TestObject, s reconciler.Status) *TestObject {
+			obj2 := *obj
+			obj2.Status = s
+			return &obj2
+		},
+		Operations: ops,
+	}
+	if batchOps, ok := ops.(reconciler.BatchOperations[*TestObject]); ok {
+		config.BatchOperations = batchOps
+	}
+	if configure != nil {
+		configure(&config)
+	}
+
+	keyIndex := statedb.Index[*TestObject, uint32]{
+		Name: tableName,
+		FromObject: func(obj *TestObject) index.KeySet {
+			return index.NewKeySet(index.Uint32(obj.Key.Key))
+		},
+		FromKey: index.Uint32,
+		Unique:  true,
+	}
+	table, err := statedb.NewTable(tableName, keyIndex)
+	require.NoError(t, err, "NewTable")
+
+	oldLogLevel := logging.DefaultLogger.GetLevel()
+	logging.SetLogLevel(logrus.ErrorLevel)
+	t.Cleanup(func() { logging.SetLogLevel(oldLogLevel) })
+
+	var db *statedb.DB
+	moduleCells := append([]cell.Cell{
+		cell.Provide(
+			func(db_ *statedb.DB) (statedb.RWTable[*TestObject], error) {
+				db = db_
+				return table, db.RegisterTable(table)
+			},
+			func() reconciler.Config[*TestObject] { return config },
+		),
+		cell.Invoke(reconciler.Register[*TestObject]),
+	}, extra...)
+
+	h := hive.New(
+		statedb.Cell,
+		reconciler.Cell,
+		job.Cell,
+
+		cell.Module(tableName, tableName, moduleCells...),
+	)
+
+	require.NoError(t, h.Start(context.Background()), "Start")
+
+	return &reconcilerHarness{db: db, table: table, keyIndex: keyIndex, hive: h}
+}
+
+// insertAndWaitDone inserts obj into h's table and blocks until it reaches
+// StatusKindDone.
+func (h *reconcilerHarness) insertAndWaitDone(t *testing.T, obj *TestObject) *TestObject {
+	t.Helper()
+
+	txn := h.db.WriteTxn(h.table)
+	h.table.Insert(txn, obj)
+	txn.Commit()
+
+	return h.waitForStatus(t, obj.Key.Key, reconciler.StatusKindDone)
+}
+
+// waitForStatus blocks until the object for key reaches the given status
+// kind, and returns it.
+func (h *reconcilerHarness) waitForStatus(t *testing.T, key uint32, kind reconciler.StatusKind) *TestObject {
+	t.Helper()
+
+	for {
+		obj, _, watch, ok := h.table.FirstWatch(h.db.ReadTxn(), h.keyIndex.Query(key))
+		if ok && obj.Status.Kind == kind {
+			return obj
+		}
+		<-watch
+	}
+}